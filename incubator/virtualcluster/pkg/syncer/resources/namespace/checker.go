@@ -29,13 +29,20 @@ import (
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/klog"
 
-	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/constants"
 	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
 	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/metrics"
 )
 
 // StartPeriodChecker starts the period checker for data consistency check. Checker is
 // blocking so should be called via a goroutine.
+//
+// The checker is now a hybrid: c.workers goroutines continuously drain
+// c.queue, which is fed by pNamespace informer events, tenant watch events
+// (OnTenantNamespaceChange), and suspect namespaces the sweep below itself
+// flags, so steady-state remediation cost is proportional to churn rather
+// than total namespace count. checkNamespaces remains as a periodic safety
+// net sweep, but it now only enqueues namespace pairs it has not already
+// seen at their current resourceVersion, instead of reconciling inline.
 func (c *controller) StartPeriodChecker(stopCh <-chan struct{}) error {
 	defer utilruntime.HandleCrash()
 
@@ -43,6 +50,8 @@ func (c *controller) StartPeriodChecker(stopCh <-chan struct{}) error {
 		return fmt.Errorf("failed to wait for caches to sync before starting Namespace checker")
 	}
 
+	c.startWorkers(stopCh)
+
 	// Start a loop to periodically check if namespaces keep consistency between super
 	// master and tenant masters.
 	wait.Until(c.checkNamespaces, c.periodCheckerPeriod, stopCh)
@@ -50,15 +59,17 @@ func (c *controller) StartPeriodChecker(stopCh <-chan struct{}) error {
 	return nil
 }
 
-// checkNamespaces checks to see if namespaces in super master informer cache and tenant master
-// keep consistency.
+// checkNamespaces walks super master and tenant namespaces and enqueues any
+// pair that changed since the previous sweep (or that has never been seen),
+// relying on c.queue's workers to actually reconcile them.
 func (c *controller) checkNamespaces() {
 	clusterNames := c.multiClusterNamespaceController.GetClusterNames()
 	if len(clusterNames) == 0 {
 		klog.Infof("tenant masters has no clusters, give up period checker")
 		return
 	}
-	defer metrics.RecordCheckerScanDuration("namespace", time.Now())
+	defer metrics.RecordCheckerScanDuration(c.metricsResource(), time.Now())
+	defer c.purgeStaleTerminatingEntries()
 	wg := sync.WaitGroup{}
 
 	for _, clusterName := range clusterNames {
@@ -70,45 +81,34 @@ func (c *controller) checkNamespaces() {
 	}
 	wg.Wait()
 
-	pNamespaces, err := c.nsLister.List(labels.Everything())
+	selector := c.namespaceSelector
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	pNamespaces, err := c.nsLister.List(selector)
 	if err != nil {
 		klog.Errorf("error listing namespaces from super master informer cache: %v", err)
 		return
 	}
 
 	for _, pNamespace := range pNamespaces {
-		clusterName, vNamespace := conversion.GetVirtualOwner(pNamespace)
-		if len(clusterName) == 0 || len(vNamespace) == 0 {
+		if c.excludeSystemNamespaces && systemNamespaces[pNamespace.Name] {
 			continue
 		}
-		shouldDelete := false
-		vNamespaceObj, err := c.multiClusterNamespaceController.Get(clusterName, "", vNamespace)
-		if errors.IsNotFound(err) {
-			shouldDelete = true
-		}
-		if err == nil {
-			vNs := vNamespaceObj.(*v1.Namespace)
-			if pNamespace.Annotations[constants.LabelUID] != string(vNs.UID) {
-				shouldDelete = true
-				klog.Warningf("Found pNamespace %s delegated UID is different from tenant object.", pNamespace.Name)
-			}
+		clusterName, vName := conversion.GetVirtualOwner(pNamespace)
+		if len(clusterName) == 0 || len(vName) == 0 {
+			continue
 		}
-		if shouldDelete {
-			// vNamespace not found and pNamespace still exist, we need to delete pNamespace manually
-			opts := &metav1.DeleteOptions{
-				PropagationPolicy: &constants.DefaultDeletionPolicy,
-				Preconditions:     metav1.NewUIDPreconditions(string(pNamespace.UID)),
-			}
-			if err := c.namespaceClient.Namespaces().Delete(pNamespace.Name, opts); err != nil {
-				klog.Errorf("error deleting pNamespace %s in super master: %v", pNamespace.Name, err)
-			} else {
-				metrics.CheckerRemedyStats.WithLabelValues("numDeletedOrphanSuperMasterNamespaces").Inc()
-			}
+		key := namespaceQueueKey(clusterName, vName)
+		if !c.pNamespaceChangedSinceLastSweep(key, pNamespace) {
+			continue
 		}
+		c.enqueueNamespacePair(clusterName, vName)
 	}
 }
 
-// checkNamespacesOfTenantCluster checks to see if namespaces in specific cluster keeps consistency.
+// checkNamespacesOfTenantCluster enqueues every vNamespace of clusterName
+// that changed since the previous sweep.
 func (c *controller) checkNamespacesOfTenantCluster(clusterName string) {
 	listObj, err := c.multiClusterNamespaceController.List(clusterName)
 	if err != nil {
@@ -117,25 +117,119 @@ func (c *controller) checkNamespacesOfTenantCluster(clusterName string) {
 	}
 	klog.V(4).Infof("check namespaces consistency in cluster %s", clusterName)
 	namespaceList := listObj.(*v1.NamespaceList)
-	for i, vNamespace := range namespaceList.Items {
-		targetNamespace := conversion.ToSuperMasterNamespace(clusterName, vNamespace.Name)
-		pNamespace, err := c.nsLister.Get(targetNamespace)
-		if errors.IsNotFound(err) {
-			// pNamespace not found and vNamespace still exists, we need to create pNamespace again
-			if err := c.multiClusterNamespaceController.RequeueObject(clusterName, &namespaceList.Items[i]); err != nil {
-				klog.Errorf("error requeue vNamespace %s in cluster %s: %v", vNamespace.Name, clusterName, err)
-			} else {
-				metrics.CheckerRemedyStats.WithLabelValues("numRequeuedTenantNamespaces").Inc()
-			}
+	for i := range namespaceList.Items {
+		vNamespace := &namespaceList.Items[i]
+		if !c.shouldCheckNamespace(vNamespace.Name, labels.Set(vNamespace.Labels)) {
+			continue
+		}
+		key := namespaceQueueKey(clusterName, vNamespace.Name)
+		if !c.vNamespaceChangedSinceLastSweep(key, vNamespace) {
 			continue
 		}
+		c.enqueueNamespacePair(clusterName, vNamespace.Name)
+	}
+}
+
+// pNamespaceChangedSinceLastSweep reports whether pNamespace's
+// resourceVersion differs from what the previous sweep recorded for key,
+// updating the recorded snapshot either way.
+func (c *controller) pNamespaceChangedSinceLastSweep(key string, pNamespace *v1.Namespace) bool {
+	prev, _ := c.lastSeen.Load(key)
+	snapshot, _ := prev.(namespaceSnapshot)
+	changed := snapshot.pResourceVersion != pNamespace.ResourceVersion
+	snapshot.pResourceVersion = pNamespace.ResourceVersion
+	c.lastSeen.Store(key, snapshot)
+	return changed
+}
 
-		if err != nil {
-			klog.Errorf("error getting pNamespace %s from super master cache: %v", targetNamespace, err)
+// vNamespaceChangedSinceLastSweep is pNamespaceChangedSinceLastSweep's
+// counterpart for the tenant side.
+func (c *controller) vNamespaceChangedSinceLastSweep(key string, vNamespace *v1.Namespace) bool {
+	prev, _ := c.lastSeen.Load(key)
+	snapshot, _ := prev.(namespaceSnapshot)
+	changed := snapshot.vResourceVersion != vNamespace.ResourceVersion
+	snapshot.vResourceVersion = vNamespace.ResourceVersion
+	c.lastSeen.Store(key, snapshot)
+	return changed
+}
+
+// waitForPNamespaceGone is called when the pNamespace informer cache has
+// already lost targetNamespace. If terminatingNamespaceCache already has a
+// fresh (within terminatingCacheTTL) record of targetNamespace being stuck,
+// it returns true immediately instead of re-polling a namespace we already
+// know is stuck. Otherwise it consults the live namespace directly via
+// namespaceClient: if the namespace is genuinely gone there is nothing to
+// wait for, but if it is still Terminating (finalizers draining) we poll
+// until it disappears or c.terminatingPollTimeout elapses. It returns true
+// if the namespace is still stuck Terminating when we give up, signalling
+// the caller to skip requeueing for this cycle.
+func (c *controller) waitForPNamespaceGone(targetNamespace string) bool {
+	if cached, ok := c.terminatingNamespaceCache.Load(targetNamespace); ok {
+		state := cached.(terminatingNamespaceState)
+		if time.Since(state.lastSeen) < terminatingCacheTTL {
+			return true
 		}
+	}
 
-		if pNamespace.Annotations[constants.LabelUID] != string(vNamespace.UID) {
-			klog.Errorf("Found pNamespace %s delegated UID is different from tenant object.", targetNamespace)
+	pNamespace, err := c.namespaceClient.Namespaces().Get(targetNamespace, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		c.terminatingNamespaceCache.Delete(targetNamespace)
+		return false
+	}
+	if err != nil {
+		klog.Errorf("error getting live pNamespace %s: %v", targetNamespace, err)
+		return false
+	}
+	if pNamespace.Status.Phase != v1.NamespaceTerminating {
+		return false
+	}
+
+	c.terminatingNamespaceCache.Store(targetNamespace, terminatingNamespaceState{
+		phase:    string(pNamespace.Status.Phase),
+		lastSeen: time.Now(),
+	})
+
+	timeout := c.terminatingPollTimeout
+	if timeout == 0 {
+		timeout = defaultTerminatingPollTimeout
+	}
+
+	stuck := true
+	err = wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+		_, getErr := c.namespaceClient.Namespaces().Get(targetNamespace, metav1.GetOptions{})
+		if errors.IsNotFound(getErr) {
+			return true, nil
+		}
+		if getErr != nil {
+			return false, getErr
 		}
+		return false, nil
+	})
+	if err == nil {
+		stuck = false
+	}
+
+	if stuck {
+		c.terminatingNamespaceCache.Store(targetNamespace, terminatingNamespaceState{
+			phase:    string(v1.NamespaceTerminating),
+			lastSeen: time.Now(),
+		})
+	} else {
+		c.terminatingNamespaceCache.Delete(targetNamespace)
 	}
+	return stuck
+}
+
+// purgeStaleTerminatingEntries drops cache entries that have not been
+// refreshed within terminatingCacheTTL, in case a namespace transitions
+// without ever being observed NotFound again (e.g. it is recreated).
+func (c *controller) purgeStaleTerminatingEntries() {
+	now := time.Now()
+	c.terminatingNamespaceCache.Range(func(key, value interface{}) bool {
+		state := value.(terminatingNamespaceState)
+		if now.Sub(state.lastSeen) > terminatingCacheTTL {
+			c.terminatingNamespaceCache.Delete(key)
+		}
+		return true
+	})
 }
@@ -0,0 +1,141 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// getResponse is one scripted reply to a Namespaces().Get call, consumed in
+// order; the last entry repeats once exhausted.
+type getResponse struct {
+	ns  *v1.Namespace
+	err error
+}
+
+func scriptedGetReactor(responses []getResponse) clienttesting.ReactionFunc {
+	i := 0
+	return func(action clienttesting.Action) (bool, runtime.Object, error) {
+		r := responses[i]
+		if i < len(responses)-1 {
+			i++
+		}
+		if r.err != nil {
+			return true, nil, r.err
+		}
+		return true, r.ns, nil
+	}
+}
+
+func terminatingNamespace(name string) *v1.Namespace {
+	return &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status:     v1.NamespaceStatus{Phase: v1.NamespaceTerminating},
+	}
+}
+
+func TestWaitForPNamespaceGone(t *testing.T) {
+	const targetNamespace = "cluster-a-tenant-ns"
+
+	tests := []struct {
+		name         string
+		responses    []getResponse
+		precache     *terminatingNamespaceState
+		pollTimeout  time.Duration
+		want         bool
+		wantCacheHas bool
+	}{
+		{
+			name: "genuinely gone",
+			responses: []getResponse{
+				{err: apierrors.NewNotFound(v1.Resource("namespaces"), targetNamespace)},
+			},
+			want:         false,
+			wantCacheHas: false,
+		},
+		{
+			name: "live and active",
+			responses: []getResponse{
+				{ns: &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: targetNamespace}}},
+			},
+			want:         false,
+			wantCacheHas: false,
+		},
+		{
+			name: "terminating then disappears within poll",
+			responses: []getResponse{
+				{ns: terminatingNamespace(targetNamespace)},
+				{err: apierrors.NewNotFound(v1.Resource("namespaces"), targetNamespace)},
+			},
+			pollTimeout:  time.Second,
+			want:         false,
+			wantCacheHas: false,
+		},
+		{
+			name: "terminating and still stuck at timeout",
+			responses: []getResponse{
+				{ns: terminatingNamespace(targetNamespace)},
+			},
+			pollTimeout:  50 * time.Millisecond,
+			want:         true,
+			wantCacheHas: true,
+		},
+		{
+			name: "fresh cache entry short-circuits without calling the client",
+			responses: []getResponse{
+				{ns: &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: targetNamespace}}},
+			},
+			precache: &terminatingNamespaceState{
+				phase:    string(v1.NamespaceTerminating),
+				lastSeen: time.Now(),
+			},
+			want:         true,
+			wantCacheHas: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			client.PrependReactor("get", "namespaces", scriptedGetReactor(tt.responses))
+
+			c := &controller{
+				namespaceClient:        client.CoreV1(),
+				terminatingPollTimeout: tt.pollTimeout,
+			}
+			if tt.precache != nil {
+				c.terminatingNamespaceCache.Store(targetNamespace, *tt.precache)
+			}
+
+			if got := c.waitForPNamespaceGone(targetNamespace); got != tt.want {
+				t.Errorf("waitForPNamespaceGone() = %v, want %v", got, tt.want)
+			}
+
+			_, cached := c.terminatingNamespaceCache.Load(targetNamespace)
+			if cached != tt.wantCacheHas {
+				t.Errorf("terminatingNamespaceCache has entry = %v, want %v", cached, tt.wantCacheHas)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// defaultTerminatingPollTimeout bounds how long reconcileNamespacePair (via
+// waitForPNamespaceGone) will wait for a pNamespace that is stuck in Terminating to fully disappear
+// before giving up on requeueing the owning vNamespace for this cycle.
+const defaultTerminatingPollTimeout = 2 * time.Minute
+
+// defaultNamespaceWorkers is the default number of goroutines draining the
+// event-driven reconciliation queue (see queue.go).
+const defaultNamespaceWorkers = 2
+
+// multiClusterNamespaceController is the subset of the generic multi cluster
+// controller that the namespace checker depends on.
+type multiClusterNamespaceController interface {
+	GetClusterNames() []string
+	List(clusterName string) (runtime.Object, error)
+	Get(clusterName, namespace, name string) (interface{}, error)
+	RequeueObject(clusterName string, obj runtime.Object) error
+
+	// RenameNamespace notifies the tenant cluster's reconciler that
+	// oldVName was renamed to newVName, so it re-keys any in-flight state
+	// it holds for that vNamespace under the new name.
+	RenameNamespace(clusterName, oldVName, newVName string) error
+}
+
+// controller is the namespace syncer controller. It keeps the super master
+// namespace objects in sync with the namespace objects of every tenant
+// (vNamespace) that the syncer serves.
+type controller struct {
+	namespaceClient                 corev1client.CoreV1Interface
+	multiClusterNamespaceController multiClusterNamespaceController
+	nsLister                        corelisters.NamespaceLister
+	nsSynced                        cache.InformerSynced
+
+	// periodCheckerPeriod is how often checkNamespaces runs its full sweep.
+	periodCheckerPeriod time.Duration
+
+	// terminatingPollTimeout bounds how long the checker polls a pNamespace
+	// that is stuck in Terminating before giving up on the current cycle.
+	terminatingPollTimeout time.Duration
+
+	// terminatingNamespaceCache remembers the last observed phase of
+	// pNamespaces we found Terminating, keyed by pNamespace name, so the
+	// periodic checker does not re-poll the same stuck namespace on every
+	// scan. Entries are purged once the namespace is gone or go stale.
+	terminatingNamespaceCache sync.Map
+
+	// namespaceSelector restricts which pNamespaces and vNamespaces the
+	// periodic checker considers, so a single super master syncer can
+	// safely coexist with other controllers that manage a subset of
+	// namespaces. Defaults to labels.Everything().
+	namespaceSelector labels.Selector
+
+	// excludeSystemNamespaces additionally skips the well known Kubernetes
+	// system namespaces regardless of namespaceSelector.
+	excludeSystemNamespaces bool
+
+	// shardName identifies this controller instance in selector-scoped
+	// checker metrics, so operators sharding checker work across multiple
+	// syncer replicas by disjoint selectors can tell shards apart.
+	shardName string
+
+	// deepCheckEnabled turns on the discovery-driven deep consistency
+	// checker (see deepcheck.go). It is off by default since it is
+	// significantly more expensive than the namespace-object-only checker.
+	deepCheckEnabled bool
+
+	// deepCheckRestConfig is the REST config the deep checker builds its
+	// own, separately rate limited discovery and metadata clients from.
+	deepCheckRestConfig *rest.Config
+
+	// deepCheckQPS and deepCheckBurst override the deep checker client's
+	// rate limit; zero means derive from deepCheckRestConfig using
+	// defaultDeepCheckQPSMultiplier/defaultDeepCheckBurstMultiplier.
+	deepCheckQPS   float32
+	deepCheckBurst int
+
+	// deepCheckWorkers bounds how many GVRs the deep checker lists
+	// concurrently; zero means defaultDeepCheckWorkers.
+	deepCheckWorkers int
+
+	// deepCheckPeriod is how often the deep checker runs; zero means
+	// defaultDeepCheckPeriod.
+	deepCheckPeriod time.Duration
+
+	discoveryClient discovery.DiscoveryInterface
+	metadataClient  metadata.Interface
+
+	// renamePolicy governs how the checker reacts to a pNamespace/vNamespace
+	// LabelUID mismatch. Defaults to RenamePolicyDelete, the original
+	// behavior of always deleting the stale pNamespace.
+	renamePolicy RenamePolicy
+
+	// queue carries "clusterName/name" namespace pairs that need
+	// reconciling sooner than the next periodic sweep: informer events,
+	// tenant watch events, and suspect namespaces the checker itself just
+	// flagged. See queue.go.
+	queue workqueue.RateLimitingInterface
+
+	// workers bounds how many queue items reconcileNamespacePair processes
+	// concurrently.
+	workers int
+
+	// lastSeen snapshots the pNamespace and vNamespace resourceVersions last observed for each
+	// "clusterName/name" pair by the periodic sweep, so the sweep only
+	// enqueues pairs that actually changed since the previous scan.
+	lastSeen sync.Map
+
+	// recorder emits Events against pNamespaces the checker cannot fully
+	// remediate on its own (e.g. a stuck deletion), so an operator watching
+	// `kubectl describe` on the pNamespace sees why. Nil unless
+	// WithEventRecorder was supplied; while it is nil, no events are emitted.
+	recorder record.EventRecorder
+}
+
+// RenamePolicy controls how the checker reacts when it finds a pNamespace
+// whose delegated LabelUID no longer matches its vNamespace's current UID.
+// A mismatch is the normal signal that the tenant's namespace was deleted
+// and superseded by an unrelated one, but it is also what a tenant-side
+// rename or delete-and-recreate-with-the-same-name looks like from here.
+type RenamePolicy string
+
+const (
+	// RenamePolicyDelete deletes the stale pNamespace unconditionally. This
+	// is the original behavior and remains the default.
+	RenamePolicyDelete RenamePolicy = "Delete"
+
+	// RenamePolicyRebind updates the pNamespace's LabelUID annotation to the
+	// new vNamespace's UID in place, provided the deep checker is enabled
+	// (WithDeepCheck), the pNamespace's recorded owner has the vNamespace's
+	// name, and the pNamespace contains no pods at all; pods are not told
+	// apart by which tenant UID they came from. Falls back to
+	// RenamePolicyDelete's behavior when those conditions are not met.
+	RenamePolicyRebind RenamePolicy = "Rebind"
+
+	// RenamePolicyReject only logs and records a metric; it never mutates
+	// the pNamespace, leaving remediation to an operator.
+	RenamePolicyReject RenamePolicy = "Reject"
+)
+
+// WithRenamePolicy overrides the default RenamePolicyDelete behavior for
+// handling a pNamespace/vNamespace LabelUID mismatch.
+func WithRenamePolicy(policy RenamePolicy) Option {
+	return func(c *controller) {
+		c.renamePolicy = policy
+	}
+}
+
+// Option configures a namespace controller at construction time.
+type Option func(*controller)
+
+// WithNamespaceSelector restricts the periodic checker to pNamespaces and
+// vNamespaces matching selector, instead of labels.Everything().
+func WithNamespaceSelector(selector labels.Selector) Option {
+	return func(c *controller) {
+		c.namespaceSelector = selector
+	}
+}
+
+// WithExcludeSystemNamespaces makes the periodic checker skip the well
+// known Kubernetes system namespaces (kube-system, kube-public,
+// kube-node-lease) in addition to namespaceSelector.
+func WithExcludeSystemNamespaces(exclude bool) Option {
+	return func(c *controller) {
+		c.excludeSystemNamespaces = exclude
+	}
+}
+
+// WithTerminatingPollTimeout overrides defaultTerminatingPollTimeout.
+func WithTerminatingPollTimeout(timeout time.Duration) Option {
+	return func(c *controller) {
+		c.terminatingPollTimeout = timeout
+	}
+}
+
+// WithWorkers bounds how many queue items reconcileNamespacePair processes
+// concurrently.
+func WithWorkers(workers int) Option {
+	return func(c *controller) {
+		c.workers = workers
+	}
+}
+
+// WithNamespaceInformer registers pNamespace add/update/delete handlers on
+// informer that feed c.queue, so the checker reacts to super master
+// namespace churn without waiting for the next periodic sweep.
+func WithNamespaceInformer(informer cache.SharedIndexInformer) Option {
+	return func(c *controller) {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    c.onPNamespaceAdd,
+			UpdateFunc: c.onPNamespaceUpdate,
+			DeleteFunc: c.onPNamespaceDelete,
+		})
+	}
+}
+
+// WithShardName labels this controller's checker metrics with shardName, so
+// operators sharding checker work across multiple syncer replicas by
+// disjoint NamespaceSelectors can attribute scan duration and remedy counts
+// per shard.
+func WithShardName(shardName string) Option {
+	return func(c *controller) {
+		c.shardName = shardName
+	}
+}
+
+// WithDeepCheck enables the discovery-driven deep consistency checker (see
+// deepcheck.go), building its dedicated clients from restConfig.
+func WithDeepCheck(restConfig *rest.Config) Option {
+	return func(c *controller) {
+		c.deepCheckEnabled = true
+		c.deepCheckRestConfig = restConfig
+	}
+}
+
+// WithDeepCheckQPS overrides the deep checker client's rate limit instead of
+// deriving it from the main REST config.
+func WithDeepCheckQPS(qps float32, burst int) Option {
+	return func(c *controller) {
+		c.deepCheckQPS = qps
+		c.deepCheckBurst = burst
+	}
+}
+
+// WithDeepCheckWorkers bounds how many GVRs the deep checker lists
+// concurrently.
+func WithDeepCheckWorkers(workers int) Option {
+	return func(c *controller) {
+		c.deepCheckWorkers = workers
+	}
+}
+
+// WithDeepCheckPeriod overrides how often the deep checker runs.
+func WithDeepCheckPeriod(period time.Duration) Option {
+	return func(c *controller) {
+		c.deepCheckPeriod = period
+	}
+}
+
+// WithEventRecorder makes the checker emit Events against pNamespaces it
+// cannot fully remediate on its own (currently just a stuck deletion),
+// alongside the existing CheckerRemedyStats metric. Events are only a
+// best-effort surface for operators watching `kubectl describe`; nothing in
+// the checker depends on them being observed, so this is optional.
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(c *controller) {
+		c.recorder = recorder
+	}
+}
+
+// metricsResource returns the resource label checkNamespaces, the queue depth
+// gauge and (with a "/deep" suffix) the deep checker report under, scoped to
+// this controller's shard when one is set.
+func (c *controller) metricsResource() string {
+	if c.shardName == "" {
+		return "namespace"
+	}
+	return "namespace/" + c.shardName
+}
+
+// remedyLabel scopes a CheckerRemedyStats label to this controller's shard,
+// so operators sharding checker work across multiple syncer replicas by
+// disjoint NamespaceSelectors can attribute remedy counts per shard.
+func (c *controller) remedyLabel(stat string) string {
+	if c.shardName == "" {
+		return stat
+	}
+	return stat + "/" + c.shardName
+}
+
+// systemNamespaces are skipped by the periodic checker when
+// excludeSystemNamespaces is set.
+var systemNamespaces = map[string]bool{
+	namespaceKubeSystem:    true,
+	namespaceKubePublic:    true,
+	namespaceKubeNodeLease: true,
+}
+
+const (
+	namespaceKubeSystem    = "kube-system"
+	namespaceKubePublic    = "kube-public"
+	namespaceKubeNodeLease = "kube-node-lease"
+)
+
+// NewNamespaceController creates a new namespace controller, applying any
+// options supplied by the caller on top of the default configuration.
+func NewNamespaceController(
+	namespaceClient corev1client.CoreV1Interface,
+	nsLister corelisters.NamespaceLister,
+	nsSynced cache.InformerSynced,
+	mcController multiClusterNamespaceController,
+	periodCheckerPeriod time.Duration,
+	options ...Option,
+) *controller {
+	c := &controller{
+		namespaceClient:                 namespaceClient,
+		multiClusterNamespaceController: mcController,
+		nsLister:                        nsLister,
+		nsSynced:                        nsSynced,
+		periodCheckerPeriod:             periodCheckerPeriod,
+		terminatingPollTimeout:          defaultTerminatingPollTimeout,
+		namespaceSelector:               labels.Everything(),
+		renamePolicy:                    RenamePolicyDelete,
+		queue:                           workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "namespace_checker"),
+		workers:                         defaultNamespaceWorkers,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+// shouldCheckNamespace reports whether a namespace (by its labels and name)
+// is within scope for the periodic checker, given namespaceSelector and
+// excludeSystemNamespaces.
+func (c *controller) shouldCheckNamespace(name string, lbls labels.Labels) bool {
+	if c.excludeSystemNamespaces && systemNamespaces[name] {
+		return false
+	}
+	selector := c.namespaceSelector
+	if selector == nil {
+		selector = labels.Everything()
+	}
+	return selector.Matches(lbls)
+}
+
+// terminatingNamespaceState is the cached bookkeeping waitForPNamespaceGone
+// keeps for a pNamespace it observed in the Terminating phase.
+type terminatingNamespaceState struct {
+	phase    string
+	lastSeen time.Time
+}
+
+// terminatingCacheTTL is how long a stale terminatingNamespaceCache entry is
+// kept around before it is purged even if the namespace was never seen again.
+const terminatingCacheTTL = 10 * time.Minute
@@ -0,0 +1,255 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/constants"
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/metrics"
+)
+
+// Defaults for the deep checker's dedicated client, matching the multiples
+// the upstream namespace controller (k8s.io/kubernetes/pkg/controller/namespace)
+// uses for its own discovery-driven content deletion: the deep checker is
+// chatty (it lists every namespaced GVR per pNamespace) so it gets a
+// generously rate limited client of its own, separate from the main syncer
+// clients it must not be allowed to starve.
+const (
+	defaultDeepCheckQPSMultiplier   = 20
+	defaultDeepCheckBurstMultiplier = 100
+	defaultDeepCheckWorkers         = 8
+	defaultDeepCheckPeriod          = 10 * time.Minute
+)
+
+// resourcesSkippedByDeepCheck are the namespaced resources this syncer's own
+// per-resource controllers (see the sibling resources/ packages) already
+// reconcile directly, so the deep checker would only be duplicating work
+// (and risking a race with the syncer's own reconciler) by also walking them.
+var resourcesSkippedByDeepCheck = map[schema.GroupVersionResource]bool{
+	{Group: "", Version: "v1", Resource: "pods"}:                            true,
+	{Group: "", Version: "v1", Resource: "services"}:                        true,
+	{Group: "", Version: "v1", Resource: "secrets"}:                         true,
+	{Group: "", Version: "v1", Resource: "configmaps"}:                      true,
+	{Group: "", Version: "v1", Resource: "serviceaccounts"}:                 true,
+	{Group: "", Version: "v1", Resource: "endpoints"}:                       true,
+	{Group: "", Version: "v1", Resource: "events"}:                          true,
+	{Group: "", Version: "v1", Resource: "persistentvolumeclaims"}:          true,
+	{Group: "networking.k8s.io", Version: "v1beta1", Resource: "ingresses"}: true,
+}
+
+// StartDeepChecker starts the opt-in discovery-driven deep consistency
+// checker, which walks every namespaced resource type in every tenant's
+// pNamespaces and garbage collects objects whose tenant owner has vanished.
+// It is disabled unless the controller was built with WithDeepCheck. Unlike
+// StartPeriodChecker it does not block: it runs the checks in its own
+// goroutine until stopCh is closed and returns right away.
+func (c *controller) StartDeepChecker(stopCh <-chan struct{}) error {
+	if !c.deepCheckEnabled {
+		return nil
+	}
+	if c.metadataClient == nil {
+		if err := c.buildDeepCheckClients(); err != nil {
+			return err
+		}
+	}
+
+	period := c.deepCheckPeriod
+	if period == 0 {
+		period = defaultDeepCheckPeriod
+	}
+
+	go func() {
+		tick := time.NewTicker(period)
+		defer tick.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-tick.C:
+				c.deepCheckTenantNamespaces()
+			}
+		}
+	}()
+	return nil
+}
+
+// buildDeepCheckClients constructs the discovery and metadata clients the
+// deep checker uses, from a copy of deepCheckRestConfig with its own,
+// generously raised rate limit so the deep checker cannot starve the main
+// syncer's clients.
+func (c *controller) buildDeepCheckClients() error {
+	if c.deepCheckRestConfig == nil {
+		return nil
+	}
+	cfg := rest.CopyConfig(c.deepCheckRestConfig)
+	qps := c.deepCheckQPS
+	if qps == 0 {
+		qps = cfg.QPS * defaultDeepCheckQPSMultiplier
+	}
+	burst := c.deepCheckBurst
+	if burst == 0 {
+		burst = cfg.Burst * defaultDeepCheckBurstMultiplier
+	}
+	cfg.QPS = qps
+	cfg.Burst = burst
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	metadataClient, err := metadata.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	c.discoveryClient = discoveryClient
+	c.metadataClient = metadataClient
+	return nil
+}
+
+// deepCheckTenantNamespaces enumerates every namespaced GVR the super master
+// serves, then for every tenant pNamespace verifies that each object therein
+// still carries a matching LabelUID for a live tenant owner, deleting those
+// that do not.
+func (c *controller) deepCheckTenantNamespaces() {
+	defer metrics.RecordCheckerScanDuration(c.metricsResource()+"/deep", time.Now())
+
+	_, resourceLists, err := c.discoveryClient.ServerPreferredNamespacedResources()
+	if err != nil {
+		klog.Errorf("error discovering namespaced resources for deep check: %v", err)
+		return
+	}
+	gvrs := namespacedGVRs(resourceLists)
+
+	pNamespaces, err := c.nsLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("error listing namespaces from super master informer cache: %v", err)
+		return
+	}
+
+	type job struct {
+		pNamespace string
+		gvr        schema.GroupVersionResource
+	}
+	jobs := make(chan job)
+	var wg sync.WaitGroup
+
+	workers := c.deepCheckWorkers
+	if workers <= 0 {
+		workers = defaultDeepCheckWorkers
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				c.deepCheckNamespaceResource(j.pNamespace, j.gvr)
+			}
+		}()
+	}
+
+	for _, pNamespace := range pNamespaces {
+		clusterName, vNamespace := conversion.GetVirtualOwner(pNamespace)
+		if len(clusterName) == 0 || len(vNamespace) == 0 {
+			continue
+		}
+		if !c.shouldCheckNamespace(pNamespace.Name, labels.Set(pNamespace.Labels)) {
+			continue
+		}
+		for _, gvr := range gvrs {
+			if resourcesSkippedByDeepCheck[gvr] {
+				continue
+			}
+			jobs <- job{pNamespace: pNamespace.Name, gvr: gvr}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// deepCheckNamespaceResource lists every object of gvr in pNamespace and
+// deletes any that is not owned by a live tenant vNamespace with a matching
+// constants.LabelUID: the owning vNamespace must both still exist and carry
+// the same UID the object was stamped with, so an object left behind by a
+// tenant namespace that was deleted and recreated under the same name (a
+// fresh UID) is caught too, not just one whose vNamespace is gone entirely.
+func (c *controller) deepCheckNamespaceResource(pNamespace string, gvr schema.GroupVersionResource) {
+	list, err := c.metadataClient.Resource(gvr).Namespace(pNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		klog.V(4).Infof("error listing %s in pNamespace %s for deep check: %v", gvr, pNamespace, err)
+		return
+	}
+
+	for i := range list.Items {
+		obj := &list.Items[i]
+		clusterName, vName := conversion.GetVirtualOwner(obj)
+		if len(clusterName) == 0 || len(vName) == 0 {
+			continue
+		}
+		vNamespaceObj, err := c.multiClusterNamespaceController.Get(clusterName, "", vName)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				klog.Errorf("error getting vNamespace %s in cluster %s for deep check of %s %s/%s: %v", vName, clusterName, gvr, pNamespace, obj.Name, err)
+				continue
+			}
+		} else {
+			vNamespace := vNamespaceObj.(*v1.Namespace)
+			if obj.Annotations[constants.LabelUID] == string(vNamespace.UID) {
+				continue
+			}
+			klog.Warningf("orphan tenant resource %s %s/%s carries stale LabelUID %s, live vNamespace %s/%s is now UID %s", gvr, pNamespace, obj.Name, obj.Annotations[constants.LabelUID], clusterName, vName, vNamespace.UID)
+		}
+		opts := &metav1.DeleteOptions{PropagationPolicy: &backgroundDeletion}
+		if err := c.metadataClient.Resource(gvr).Namespace(pNamespace).Delete(obj.Name, opts); err != nil {
+			klog.Errorf("error deleting orphan tenant resource %s %s/%s: %v", gvr, pNamespace, obj.Name, err)
+			continue
+		}
+		metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numDeletedOrphanTenantResources")).Inc()
+	}
+}
+
+// namespacedGVRs flattens the discovery client's grouped, versioned
+// APIResourceLists into the namespaced-only GroupVersionResources.
+func namespacedGVRs(lists []*metav1.APIResourceList) []schema.GroupVersionResource {
+	var gvrs []schema.GroupVersionResource
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, res := range list.APIResources {
+			if !res.Namespaced {
+				continue
+			}
+			gvrs = append(gvrs, gv.WithResource(res.Name))
+		}
+	}
+	return gvrs
+}
+
+var backgroundDeletion = metav1.DeletePropagationBackground
@@ -0,0 +1,251 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/constants"
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/metrics"
+)
+
+// namespaceSnapshot is what the periodic sweep remembers about a namespace
+// pair from its previous scan, so it can tell whether the pair actually
+// changed and is worth enqueueing again.
+type namespaceSnapshot struct {
+	pResourceVersion string
+	vResourceVersion string
+}
+
+// namespaceQueueKey packs a (clusterName, name) pair into a single
+// workqueue item.
+func namespaceQueueKey(clusterName, name string) string {
+	return clusterName + "/" + name
+}
+
+// splitNamespaceQueueKey is the inverse of namespaceQueueKey.
+func splitNamespaceQueueKey(key string) (clusterName, name string, err error) {
+	i := strings.Index(key, "/")
+	if i < 0 {
+		return "", "", fmt.Errorf("malformed namespace queue key %q", key)
+	}
+	return key[:i], key[i+1:], nil
+}
+
+// enqueueNamespacePair schedules (clusterName, name) for reconciliation by a
+// queue worker. It is the common entry point for informer event handlers,
+// tenant watch events, and the periodic sweep's change detection.
+func (c *controller) enqueueNamespacePair(clusterName, name string) {
+	c.queue.Add(namespaceQueueKey(clusterName, name))
+}
+
+// OnTenantNamespaceChange is the entry point multiClusterNamespaceController
+// is expected to call whenever its watch on clusterName observes an add,
+// update, or delete of the vNamespace called name, so the checker reacts to
+// tenant-side churn without waiting for the next periodic sweep.
+func (c *controller) OnTenantNamespaceChange(clusterName, name string) {
+	c.enqueueNamespacePair(clusterName, name)
+}
+
+// onPNamespaceAdd/Update/Delete are registered as pNamespace informer event
+// handlers (see WithNamespaceInformer). They translate a pNamespace event
+// into the (clusterName, vName) pair it belongs to and enqueue it.
+func (c *controller) onPNamespaceAdd(obj interface{}) {
+	pNamespace, ok := obj.(*v1.Namespace)
+	if !ok {
+		return
+	}
+	c.enqueuePNamespace(pNamespace)
+}
+
+func (c *controller) onPNamespaceUpdate(_, newObj interface{}) {
+	pNamespace, ok := newObj.(*v1.Namespace)
+	if !ok {
+		return
+	}
+	c.enqueuePNamespace(pNamespace)
+}
+
+func (c *controller) onPNamespaceDelete(obj interface{}) {
+	pNamespace, ok := obj.(*v1.Namespace)
+	if !ok {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			pNamespace, ok = tombstone.Obj.(*v1.Namespace)
+			if !ok {
+				return
+			}
+		} else {
+			return
+		}
+	}
+	c.enqueuePNamespace(pNamespace)
+}
+
+func (c *controller) enqueuePNamespace(pNamespace *v1.Namespace) {
+	clusterName, vName := conversion.GetVirtualOwner(pNamespace)
+	if len(clusterName) == 0 || len(vName) == 0 {
+		return
+	}
+	c.enqueueNamespacePair(clusterName, vName)
+}
+
+// runWorker drains c.queue until it is shut down.
+func (c *controller) runWorker() {
+	for c.processNextWorkItem() {
+	}
+}
+
+func (c *controller) processNextWorkItem() bool {
+	key, quit := c.queue.Get()
+	if quit {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.syncNamespacePair(key.(string)); err != nil {
+		utilruntime.HandleError(fmt.Errorf("error syncing namespace pair %q, retrying: %v", key, err))
+		c.queue.AddRateLimited(key)
+		return true
+	}
+	c.queue.Forget(key)
+	return true
+}
+
+func (c *controller) syncNamespacePair(key string) error {
+	clusterName, name, err := splitNamespaceQueueKey(key)
+	if err != nil {
+		return nil
+	}
+	return c.reconcileNamespacePair(clusterName, name)
+}
+
+// reconcileNamespacePair is the unit of work queue workers (and, via
+// checkNamespaces, the periodic sweep) consume: it reconciles a single
+// (clusterName, name) vNamespace/pNamespace pair regardless of which side
+// triggered the check. A non-nil return enqueues a rate limited retry
+// instead of waiting for the next periodic sweep or event.
+func (c *controller) reconcileNamespacePair(clusterName, name string) error {
+	if c.excludeSystemNamespaces && systemNamespaces[name] {
+		return nil
+	}
+
+	targetNamespace := conversion.ToSuperMasterNamespace(clusterName, name)
+	pNamespace, pErr := c.nsLister.Get(targetNamespace)
+	if pErr != nil && !errors.IsNotFound(pErr) {
+		return fmt.Errorf("error getting pNamespace %s from super master cache: %v", targetNamespace, pErr)
+	}
+
+	vNamespaceObj, vErr := c.multiClusterNamespaceController.Get(clusterName, "", name)
+	if vErr != nil && !errors.IsNotFound(vErr) {
+		return fmt.Errorf("error getting vNamespace %s in cluster %s: %v", name, clusterName, vErr)
+	}
+
+	// A renamed vNamespace must be reconciled via reconcileRenamedNamespace
+	// regardless of whether its new pNamespace has shown up in the lister
+	// yet: right after a rename the new pNamespace by definition does not
+	// exist, so checking this ahead of the pErr/vErr switch below keeps the
+	// "pNamespace missing, maybe stuck Terminating" case from swallowing it.
+	if vErr == nil {
+		vNamespace := vNamespaceObj.(*v1.Namespace)
+		if _, renamed := vNamespace.Annotations[constants.LabelRenamedFrom]; renamed {
+			c.reconcileRenamedNamespace(clusterName, vNamespace)
+			return nil
+		}
+	}
+
+	switch {
+	case errors.IsNotFound(vErr) && pNamespace != nil:
+		// vNamespace gone, pNamespace still exists: orphaned, clean it up.
+		return c.deleteOrphanPNamespace(pNamespace)
+
+	case vErr == nil && errors.IsNotFound(pErr):
+		// vNamespace exists, pNamespace missing from the lister: it may
+		// just be stuck Terminating rather than actually gone.
+		vNamespace := vNamespaceObj.(*v1.Namespace)
+		if stuck := c.waitForPNamespaceGone(targetNamespace); stuck {
+			klog.Warningf("pNamespace %s is stuck Terminating, skip requeue this cycle", targetNamespace)
+			metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numStuckTerminatingSuperMasterNamespaces")).Inc()
+			return nil
+		}
+		if err := c.multiClusterNamespaceController.RequeueObject(clusterName, vNamespace); err != nil {
+			return fmt.Errorf("error requeue vNamespace %s in cluster %s: %v", name, clusterName, err)
+		}
+		metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numRequeuedTenantNamespaces")).Inc()
+		return nil
+
+	case vErr == nil && pErr == nil:
+		vNamespace := vNamespaceObj.(*v1.Namespace)
+		if pNamespace.Annotations[constants.LabelUID] != string(vNamespace.UID) {
+			klog.Warningf("Found pNamespace %s delegated UID is different from tenant object.", targetNamespace)
+			if c.reconcileUIDMismatch(pNamespace, vNamespace) {
+				return c.deleteOrphanPNamespace(pNamespace)
+			}
+		}
+		return nil
+
+	default:
+		// Both sides missing: nothing to reconcile.
+		return nil
+	}
+}
+
+// deleteOrphanPNamespace deletes pNamespace now that its vNamespace is gone
+// (or was superseded), unless it is already stuck Terminating.
+func (c *controller) deleteOrphanPNamespace(pNamespace *v1.Namespace) error {
+	if pNamespace.DeletionTimestamp != nil {
+		klog.Warningf("pNamespace %s is stuck deleting since %s", pNamespace.Name, pNamespace.DeletionTimestamp)
+		metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numStuckDeletingSuperMasterNamespaces")).Inc()
+		if c.recorder != nil {
+			c.recorder.Eventf(pNamespace, v1.EventTypeWarning, "StuckDeleting", "pNamespace has been Terminating since %s, its finalizers may need operator attention", pNamespace.DeletionTimestamp)
+		}
+		return nil
+	}
+	opts := &metav1.DeleteOptions{
+		PropagationPolicy: &constants.DefaultDeletionPolicy,
+		Preconditions:     metav1.NewUIDPreconditions(string(pNamespace.UID)),
+	}
+	if err := c.namespaceClient.Namespaces().Delete(pNamespace.Name, opts); err != nil {
+		return fmt.Errorf("error deleting pNamespace %s in super master: %v", pNamespace.Name, err)
+	}
+	metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numDeletedOrphanSuperMasterNamespaces")).Inc()
+	return nil
+}
+
+// startWorkers launches c.workers goroutines draining c.queue until stopCh
+// is closed, and a goroutine reporting the queue depth gauge.
+func (c *controller) startWorkers(stopCh <-chan struct{}) {
+	for i := 0; i < c.workers; i++ {
+		go wait.Until(c.runWorker, time.Second, stopCh)
+	}
+	go wait.Until(func() {
+		metrics.RecordCheckerQueueDepth(c.metricsResource(), c.queue.Len())
+	}, time.Second, stopCh)
+	go func() {
+		<-stopCh
+		c.queue.ShutDown()
+	}()
+}
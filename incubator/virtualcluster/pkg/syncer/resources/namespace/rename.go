@@ -0,0 +1,181 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/constants"
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/metrics"
+)
+
+// RenameNamespace notifies clusterName's multi cluster controller that
+// oldVName was renamed to newVName, then lets the next checker cycle
+// reconcile the super master side via the constants.LabelRenamedFrom
+// annotation the tenant is expected to have set on newVName.
+func (c *controller) RenameNamespace(clusterName, oldVName, newVName string) error {
+	return c.multiClusterNamespaceController.RenameNamespace(clusterName, oldVName, newVName)
+}
+
+// reconcileUIDMismatch is called by reconcileNamespacePair when a pNamespace's
+// delegated LabelUID no longer matches its vNamespace's current UID. It
+// applies c.renamePolicy and reports whether the caller should still delete
+// pNamespace.
+func (c *controller) reconcileUIDMismatch(pNamespace *v1.Namespace, vNs *v1.Namespace) (shouldDelete bool) {
+	switch c.renamePolicy {
+	case RenamePolicyReject:
+		klog.Warningf("pNamespace %s delegated UID differs from tenant object, RenamePolicyReject leaves it as is", pNamespace.Name)
+		metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numRejectedRenamedNamespaces")).Inc()
+		return false
+
+	case RenamePolicyRebind:
+		// Rebind only relocates the pNamespace itself; any other
+		// tenant-owned resource left over from the previous UID (secrets,
+		// configmaps, services, ...) depends on the deep checker to be
+		// garbage collected, since pNamespaceHasLiveWorkloads below only
+		// looks at pods. Without it enabled, rebinding would silently leave
+		// those resources behind, now reachable under the new tenant, so
+		// refuse and fall back to delete instead.
+		if !c.deepCheckEnabled {
+			klog.Warningf("pNamespace %s: RenamePolicyRebind requires WithDeepCheck to garbage collect resources left over from the previous tenant UID, falling back to delete since it is not enabled", pNamespace.Name)
+			metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numRebindRefusedMissingDeepCheck")).Inc()
+			return true
+		}
+
+		clusterName, vName := conversion.GetVirtualOwner(pNamespace)
+		sameTenant := clusterName != "" && vName == vNs.Name
+		if !sameTenant {
+			klog.Warningf("pNamespace %s delegated UID differs from a vNamespace of a different name/cluster, falling back to delete", pNamespace.Name)
+			return true
+		}
+		previousUID := pNamespace.Annotations[constants.LabelUID]
+		if c.pNamespaceHasLiveWorkloads(pNamespace.Name, previousUID) {
+			klog.Warningf("pNamespace %s still has workloads owned by UID %s, falling back to delete", pNamespace.Name, previousUID)
+			return true
+		}
+		if err := c.rebindPNamespace(pNamespace, vNs); err != nil {
+			klog.Errorf("error rebinding pNamespace %s to new tenant UID %s: %v", pNamespace.Name, vNs.UID, err)
+			return true
+		}
+		metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numRebindedNamespaces")).Inc()
+		return false
+
+	default: // RenamePolicyDelete
+		return true
+	}
+}
+
+// rebindPNamespace updates pNamespace's LabelUID annotation in place to
+// vNs.UID, so the pNamespace is kept instead of being deleted and recreated.
+func (c *controller) rebindPNamespace(pNamespace *v1.Namespace, vNs *v1.Namespace) error {
+	updated := pNamespace.DeepCopy()
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[constants.LabelUID] = string(vNs.UID)
+	_, err := c.namespaceClient.Namespaces().Update(updated)
+	return err
+}
+
+// pNamespaceHasLiveWorkloads reports whether pNamespace still contains any
+// pods. previousUID is accepted for logging context: it is the tenant UID a
+// caller is trying to rebind or GC away from. We only need to check pods
+// here: any other tenant-owned resource that matters is itself eventually
+// garbage collected by the deep checker once the rebind or delete below
+// resolves the namespace's own ownership.
+func (c *controller) pNamespaceHasLiveWorkloads(pNamespaceName, previousUID string) bool {
+	pods, err := c.namespaceClient.Pods(pNamespaceName).List(metav1.ListOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false
+		}
+		klog.Errorf("error listing pods in pNamespace %s (previous tenant UID %s) to check for live workloads: %v", pNamespaceName, previousUID, err)
+		// Be conservative: if we cannot tell, assume there are live
+		// workloads rather than rebind or GC over them.
+		return true
+	}
+	return len(pods.Items) > 0
+}
+
+// reconcileRenamedNamespace handles a vNamespace annotated with
+// constants.LabelRenamedFrom=<old>: it creates the new pNamespace (copying
+// labels/annotations from the old one) if it does not exist yet, and marks
+// the old pNamespace for GC once it has no children left.
+func (c *controller) reconcileRenamedNamespace(clusterName string, vNamespace *v1.Namespace) {
+	oldVName, ok := vNamespace.Annotations[constants.LabelRenamedFrom]
+	if !ok || oldVName == "" {
+		return
+	}
+
+	oldTargetNamespace := conversion.ToSuperMasterNamespace(clusterName, oldVName)
+	newTargetNamespace := conversion.ToSuperMasterNamespace(clusterName, vNamespace.Name)
+
+	oldPNamespace, err := c.nsLister.Get(oldTargetNamespace)
+	if err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("error getting old pNamespace %s for rename reconciliation: %v", oldTargetNamespace, err)
+		return
+	}
+
+	if _, err := c.nsLister.Get(newTargetNamespace); errors.IsNotFound(err) {
+		newPNamespace := &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        newTargetNamespace,
+				Labels:      map[string]string{},
+				Annotations: map[string]string{},
+			},
+		}
+		if oldPNamespace != nil {
+			for k, v := range oldPNamespace.Labels {
+				newPNamespace.Labels[k] = v
+			}
+			for k, v := range oldPNamespace.Annotations {
+				newPNamespace.Annotations[k] = v
+			}
+		}
+		// GetVirtualOwner resolves ownership from these annotations, not
+		// from the pNamespace name, so copying oldPNamespace's annotations
+		// verbatim would leave the new pNamespace pointing at oldVName.
+		// Re-derive LabelCluster/LabelNamespace/LabelUID to the new tenant
+		// identity the checker must attribute this pNamespace to.
+		newPNamespace.Annotations[constants.LabelCluster] = clusterName
+		newPNamespace.Annotations[constants.LabelNamespace] = vNamespace.Name
+		newPNamespace.Annotations[constants.LabelUID] = string(vNamespace.UID)
+		if _, err := c.namespaceClient.Namespaces().Create(newPNamespace); err != nil && !errors.IsAlreadyExists(err) {
+			klog.Errorf("error creating renamed pNamespace %s: %v", newTargetNamespace, err)
+			return
+		}
+		metrics.CheckerRemedyStats.WithLabelValues(c.remedyLabel("numRenamedNamespaces")).Inc()
+	}
+
+	if oldPNamespace == nil {
+		return
+	}
+	if c.pNamespaceHasLiveWorkloads(oldPNamespace.Name, "") {
+		klog.V(4).Infof("old pNamespace %s still has children, deferring GC", oldPNamespace.Name)
+		return
+	}
+	opts := &metav1.DeleteOptions{
+		PropagationPolicy: &constants.DefaultDeletionPolicy,
+		Preconditions:     metav1.NewUIDPreconditions(string(oldPNamespace.UID)),
+	}
+	if err := c.namespaceClient.Namespaces().Delete(oldPNamespace.Name, opts); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("error deleting old pNamespace %s after rename: %v", oldPNamespace.Name, err)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+ Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespace
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/constants"
+	"github.com/kubernetes-sigs/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
+)
+
+func TestReconcileUIDMismatch(t *testing.T) {
+	const pNamespaceName = "cluster-a-tenant-ns"
+
+	newPNamespace := func(annotations map[string]string) *v1.Namespace {
+		return &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        pNamespaceName,
+				UID:         types.UID("old-uid"),
+				Annotations: annotations,
+			},
+		}
+	}
+	newVNamespace := func() *v1.Namespace {
+		return &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant-ns", UID: types.UID("new-uid")},
+		}
+	}
+
+	tests := []struct {
+		name             string
+		policy           RenamePolicy
+		annotations      map[string]string
+		pods             []runtime.Object
+		deepCheckEnabled bool
+		wantDelete       bool
+		wantRebound      bool
+	}{
+		{
+			name:       "reject never deletes or rebinds",
+			policy:     RenamePolicyReject,
+			wantDelete: false,
+		},
+		{
+			name:       "delete is the default, always deletes",
+			policy:     RenamePolicyDelete,
+			wantDelete: true,
+		},
+		{
+			name:   "rebind refuses and falls back to delete when the deep checker is not enabled",
+			policy: RenamePolicyRebind,
+			annotations: map[string]string{
+				constants.LabelCluster:   "cluster-a",
+				constants.LabelNamespace: "tenant-ns",
+			},
+			deepCheckEnabled: false,
+			wantDelete:       true,
+		},
+		{
+			name:   "rebind falls back to delete when the pNamespace belongs to a different tenant",
+			policy: RenamePolicyRebind,
+			annotations: map[string]string{
+				constants.LabelCluster:   "cluster-a",
+				constants.LabelNamespace: "some-other-ns",
+			},
+			deepCheckEnabled: true,
+			wantDelete:       true,
+		},
+		{
+			name:   "rebind falls back to delete when the pNamespace still has live pods",
+			policy: RenamePolicyRebind,
+			annotations: map[string]string{
+				constants.LabelCluster:   "cluster-a",
+				constants.LabelNamespace: "tenant-ns",
+			},
+			pods: []runtime.Object{
+				&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "leftover", Namespace: pNamespaceName}},
+			},
+			deepCheckEnabled: true,
+			wantDelete:       true,
+		},
+		{
+			name:   "rebind updates LabelUID in place and keeps the pNamespace",
+			policy: RenamePolicyRebind,
+			annotations: map[string]string{
+				constants.LabelCluster:   "cluster-a",
+				constants.LabelNamespace: "tenant-ns",
+				constants.LabelUID:       "old-tenant-uid",
+			},
+			deepCheckEnabled: true,
+			wantDelete:       false,
+			wantRebound:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pNamespace := newPNamespace(tt.annotations)
+			vNamespace := newVNamespace()
+
+			objs := append([]runtime.Object{pNamespace}, tt.pods...)
+			client := fake.NewSimpleClientset(objs...)
+
+			c := &controller{
+				namespaceClient:  client.CoreV1(),
+				renamePolicy:     tt.policy,
+				deepCheckEnabled: tt.deepCheckEnabled,
+			}
+
+			gotDelete := c.reconcileUIDMismatch(pNamespace, vNamespace)
+			if gotDelete != tt.wantDelete {
+				t.Errorf("reconcileUIDMismatch() = %v, want %v", gotDelete, tt.wantDelete)
+			}
+
+			if tt.wantRebound {
+				updated, err := client.CoreV1().Namespaces().Get(pNamespaceName, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("unexpected error fetching pNamespace after rebind: %v", err)
+				}
+				if got := updated.Annotations[constants.LabelUID]; got != string(vNamespace.UID) {
+					t.Errorf("LabelUID after rebind = %q, want %q", got, vNamespace.UID)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileRenamedNamespaceOwnershipAnnotations(t *testing.T) {
+	const clusterName = "cluster-a"
+	const oldVName = "old-tenant-ns"
+	const newVName = "new-tenant-ns"
+
+	oldTargetNamespace := conversion.ToSuperMasterNamespace(clusterName, oldVName)
+	newTargetNamespace := conversion.ToSuperMasterNamespace(clusterName, newVName)
+
+	oldPNamespace := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: oldTargetNamespace,
+			Annotations: map[string]string{
+				constants.LabelCluster:   clusterName,
+				constants.LabelNamespace: oldVName,
+				constants.LabelUID:       "old-uid",
+			},
+		},
+	}
+	vNamespace := &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        newVName,
+			UID:         types.UID("new-uid"),
+			Annotations: map[string]string{constants.LabelRenamedFrom: oldVName},
+		},
+	}
+
+	client := fake.NewSimpleClientset(oldPNamespace)
+	c := &controller{
+		namespaceClient: client.CoreV1(),
+		nsLister:        newNsLister(oldPNamespace),
+	}
+
+	c.reconcileRenamedNamespace(clusterName, vNamespace)
+
+	newPNamespace, err := client.CoreV1().Namespaces().Get(newTargetNamespace, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected renamed pNamespace %s to be created, got err=%v", newTargetNamespace, err)
+	}
+	if got := newPNamespace.Annotations[constants.LabelCluster]; got != clusterName {
+		t.Errorf("LabelCluster = %q, want %q", got, clusterName)
+	}
+	if got := newPNamespace.Annotations[constants.LabelNamespace]; got != newVName {
+		t.Errorf("LabelNamespace = %q, want %q (not the copied-over %q)", got, newVName, oldVName)
+	}
+	if got := newPNamespace.Annotations[constants.LabelUID]; got != string(vNamespace.UID) {
+		t.Errorf("LabelUID = %q, want %q", got, vNamespace.UID)
+	}
+}